@@ -5,10 +5,42 @@ import (
 	"github.com/ollama/ollama/ml/nn/fast/rope"
 )
 
+// RoPE applies rotary position embeddings to t. base and scale are the
+// unscaled RoPE base frequency and multiplier. Linear, NTK, YaRN, and
+// Llama3 scaling are configured via options (see rope.WithScalingType and
+// friends); when set, and the caller hasn't supplied its own
+// rope.WithFactors tensor, RoPE computes the corresponding per-dimension
+// inverse-frequency corrections (rope.Options.Frequencies) and builds the
+// Factors tensor the backend rotates with, so individual models don't
+// need to precompute one themselves.
+//
+// When YaRN scaling is active, the result is also scaled by
+// rope.Options.YarnAttnFactor to compensate for the distributional shift
+// YaRN's frequency-domain ramp introduces into attention magnitudes.
 func RoPE(ctx ml.Context, t, positionIDs ml.Tensor, dim uint32, base, scale float32, options ...func(*rope.Options)) ml.Tensor {
-	if t, ok := t.(rope.RoPE); ok {
-		return t.RoPE(ctx, positionIDs, dim, base, scale, options...)
+	var opts rope.Options
+	for _, option := range options {
+		option(&opts)
 	}
 
-	panic("RoPE not implemented for this tensor type")
+	if opts.Factors == nil && opts.ScalingType != rope.ScalingNone {
+		if freqs := opts.Frequencies(dim, base); freqs != nil {
+			factors, err := ctx.Input().FromFloatSlice(freqs, len(freqs))
+			if err != nil {
+				panic(err)
+			}
+			opts.Factors = factors
+		}
+	}
+
+	tr, ok := t.(rope.RoPE)
+	if !ok {
+		panic("RoPE not implemented for this tensor type")
+	}
+
+	out := tr.RoPE(ctx, positionIDs, dim, base, scale, func(o *rope.Options) { *o = opts })
+	if opts.ScalingType == rope.ScalingYaRN && opts.YarnAttnFactor != 0 && opts.YarnAttnFactor != 1 {
+		out = out.Scale(ctx, float64(opts.YarnAttnFactor))
+	}
+	return out
 }
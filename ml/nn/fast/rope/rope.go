@@ -1,16 +1,82 @@
 package rope
 
-import "github.com/ollama/ollama/ml"
+import (
+	"math"
+
+	"github.com/ollama/ollama/ml"
+)
 
 type RoPE interface {
 	RoPE(ctx ml.Context, positionIDs ml.Tensor, dim uint32, base, scale float32, options ...func(*Options)) ml.Tensor
 }
 
+// Type identifies the RoPE rotation scheme applied to a tensor's dimensions.
+type Type uint32
+
+const (
+	// Norm applies rotation across the full head dimension, pairing
+	// adjacent elements (x[2i], x[2i+1]).
+	Norm Type = 0
+	// NeoX applies rotation across the full head dimension, pairing
+	// elements from the two halves of the dimension (x[i], x[i+dim/2]).
+	NeoX Type = 2
+	// MRope applies multi-axis (e.g. temporal/height/width) rotation, as
+	// used by Qwen2-VL style vision-language models.
+	MRope Type = 8
+	// Vision applies the rotation scheme used by vision transformer
+	// position embeddings.
+	Vision Type = 24
+)
+
+// ScalingType identifies the frequency-scaling scheme applied to a RoPE
+// tensor's base inverse frequencies before rotation.
+type ScalingType uint32
+
+const (
+	// ScalingNone applies no frequency scaling.
+	ScalingNone ScalingType = iota
+	// ScalingLinear implements linear position interpolation (PI): every
+	// inverse frequency is divided by a fixed factor, stretching the
+	// effective context length.
+	ScalingLinear
+	// ScalingNTK implements NTK-aware scaling, which adjusts the RoPE base
+	// itself so that high frequencies are left largely intact while low
+	// frequencies are stretched.
+	ScalingNTK
+	// ScalingYaRN implements YaRN (Yet another RoPE extensioN), which
+	// blends linear interpolation and extrapolation across frequency bands
+	// using ramp parameters, with an optional attention scaling factor.
+	ScalingYaRN
+	// ScalingLlama3 implements the Llama 3.1 rope_scaling scheme, which
+	// smoothly interpolates between low and high frequency factors around
+	// the model's original context length.
+	ScalingLlama3
+)
+
 // Options contains optional parameters for RoPE function
 type Options struct {
 	OriginalContextLength uint32
-	Type                  uint32
+	Type                  Type
 	Factors               ml.Tensor
+
+	ScalingType ScalingType
+
+	// ScalingFactor is the linear (PI) or Llama3 scaling factor applied to
+	// position indices or inverse frequencies, depending on ScalingType.
+	ScalingFactor float32
+
+	// YarnBetaFast and YarnBetaSlow bound the ramp, in rotations, over
+	// which YaRN blends from extrapolation to interpolation.
+	YarnBetaFast float32
+	YarnBetaSlow float32
+	// YarnAttnFactor scales attention magnitudes to compensate for the
+	// distributional shift introduced by YaRN scaling.
+	YarnAttnFactor float32
+
+	// Llama3LowFreqFactor and Llama3HighFreqFactor bound the wavelength
+	// ramp used by the Llama 3.1 rope_scaling scheme.
+	Llama3LowFreqFactor  float32
+	Llama3HighFreqFactor float32
 }
 
 // WithOriginalContextLength sets a custom context length
@@ -20,13 +86,18 @@ func WithOriginalContextLength(len uint32) func(*Options) {
 	}
 }
 
-// WithType sets RoPE type to NeoX
-func WithTypeNeoX() func(*Options) {
+// WithType sets the RoPE rotation scheme
+func WithType(t Type) func(*Options) {
 	return func(opts *Options) {
-		opts.Type = 2
+		opts.Type = t
 	}
 }
 
+// WithTypeNeoX sets RoPE type to NeoX
+func WithTypeNeoX() func(*Options) {
+	return WithType(NeoX)
+}
+
 // WithFactors sets custom rope factors
 func WithFactors(factors ml.Tensor) func(*Options) {
 	return func(opts *Options) {
@@ -35,3 +106,163 @@ func WithFactors(factors ml.Tensor) func(*Options) {
 		}
 	}
 }
+
+// WithScalingType sets the frequency-scaling scheme applied before
+// rotation. It is typically combined with WithLinearScaling, WithYarn, or
+// WithLlama3Scaling to set the scheme's parameters.
+func WithScalingType(kind ScalingType) func(*Options) {
+	return func(opts *Options) {
+		opts.ScalingType = kind
+	}
+}
+
+// WithLinearScaling configures linear position interpolation (PI), dividing
+// every inverse frequency by factor.
+func WithLinearScaling(factor float32) func(*Options) {
+	return func(opts *Options) {
+		opts.ScalingType = ScalingLinear
+		opts.ScalingFactor = factor
+	}
+}
+
+// WithNTKScaling configures NTK-aware scaling, raising the RoPE base by
+// factor^(dim/(dim-2)) so that the first rotation pair is left unscaled and
+// the last pair's inverse frequency is divided by factor.
+func WithNTKScaling(factor float32) func(*Options) {
+	return func(opts *Options) {
+		opts.ScalingType = ScalingNTK
+		opts.ScalingFactor = factor
+	}
+}
+
+// WithYarn configures YaRN scaling. betaFast and betaSlow bound the ramp,
+// in rotations, over which YaRN blends from extrapolation to interpolation;
+// mscale sets the overall frequency scaling factor; attnFactor scales
+// attention magnitudes to compensate for the distributional shift YaRN
+// introduces.
+func WithYarn(betaFast, betaSlow, mscale, attnFactor float32) func(*Options) {
+	return func(opts *Options) {
+		opts.ScalingType = ScalingYaRN
+		opts.YarnBetaFast = betaFast
+		opts.YarnBetaSlow = betaSlow
+		opts.ScalingFactor = mscale
+		opts.YarnAttnFactor = attnFactor
+	}
+}
+
+// WithLlama3Scaling configures the Llama 3.1 rope_scaling scheme. factor is
+// the overall wavelength scaling factor; lowFreqFactor and highFreqFactor
+// bound the smoothing ramp; origCtx is the model's original (pre-scaling)
+// context length.
+func WithLlama3Scaling(factor, lowFreqFactor, highFreqFactor float32, origCtx uint32) func(*Options) {
+	return func(opts *Options) {
+		opts.ScalingType = ScalingLlama3
+		opts.ScalingFactor = factor
+		opts.Llama3LowFreqFactor = lowFreqFactor
+		opts.Llama3HighFreqFactor = highFreqFactor
+		opts.OriginalContextLength = origCtx
+	}
+}
+
+// Frequencies computes the per-dimension inverse frequency correction for
+// the configured ScalingType, one value per (dim/2) rotation pair, given
+// the tensor's head dimension and unscaled RoPE base. It returns nil for
+// ScalingNone, in which case no correction is needed.
+//
+// Callers multiply each dimension's base inverse frequency
+// (base^(-2i/dim)) by the corresponding element of the returned slice to
+// get the corrected inverse frequency actually used for rotation. This is
+// the computation a backend would otherwise require a precomputed
+// rope.WithFactors tensor to supply.
+func (opts Options) Frequencies(dim uint32, base float32) []float32 {
+	n := int(dim / 2)
+	switch opts.ScalingType {
+	case ScalingNone:
+		return nil
+	case ScalingLinear:
+		factors := make([]float32, n)
+		for i := range factors {
+			factors[i] = 1 / opts.ScalingFactor
+		}
+		return factors
+	case ScalingNTK:
+		// NTK-aware scaling raises the effective base so that only the
+		// lowest frequencies (which wrap around within the original
+		// context) are stretched, leaving high frequencies untouched.
+		adjBase := base * float32(math.Pow(float64(opts.ScalingFactor), float64(dim)/float64(dim-2)))
+		factors := make([]float32, n)
+		for i := range factors {
+			exp := float64(2*i) / float64(dim)
+			orig := math.Pow(float64(base), -exp)
+			adj := math.Pow(float64(adjBase), -exp)
+			factors[i] = float32(adj / orig)
+		}
+		return factors
+	case ScalingYaRN:
+		return yarnFrequencies(n, dim, base, opts)
+	case ScalingLlama3:
+		return llama3Frequencies(n, dim, base, opts)
+	default:
+		return nil
+	}
+}
+
+// yarnCorrectionDim returns the fractional rotation-pair index whose
+// frequency completes exactly numRotations full rotations across the
+// model's original context length.
+func yarnCorrectionDim(dim, origCtx uint32, numRotations, base float32) float64 {
+	return float64(dim) * math.Log(float64(origCtx)/(float64(numRotations)*2*math.Pi)) / (2 * math.Log(float64(base)))
+}
+
+// yarnFrequencies implements the YaRN interpolation/extrapolation blend:
+// dimensions below the beta-fast correction bound are extrapolated
+// unchanged, dimensions above the beta-slow bound are fully interpolated
+// (divided by ScalingFactor), and dimensions in between are ramped
+// linearly across that range.
+func yarnFrequencies(n int, dim uint32, base float32, opts Options) []float32 {
+	origCtx := opts.OriginalContextLength
+	if origCtx == 0 {
+		origCtx = 1
+	}
+
+	low := math.Max(0, math.Floor(yarnCorrectionDim(dim, origCtx, opts.YarnBetaFast, base)))
+	high := math.Min(float64(dim)-1, math.Ceil(yarnCorrectionDim(dim, origCtx, opts.YarnBetaSlow, base)))
+
+	factors := make([]float32, n)
+	for i := range factors {
+		rampDenom := math.Max(0.001, high-low)
+		ramp := (float64(i) - low) / rampDenom
+		ramp = math.Min(1, math.Max(0, ramp)) // 0 at low end (extrapolate), 1 at high end (interpolate)
+
+		factors[i] = float32(ramp*float64(1/opts.ScalingFactor) + (1-ramp)*1)
+	}
+	return factors
+}
+
+// llama3Frequencies implements the Llama 3.1 rope_scaling scheme: high
+// frequencies (short wavelength) are left unscaled, low frequencies (long
+// wavelength) are divided by factor, and frequencies in between are
+// smoothly ramped between the two.
+func llama3Frequencies(n int, dim uint32, base float32, opts Options) []float32 {
+	origCtx := float64(opts.OriginalContextLength)
+	lowFreqWavelen := origCtx / float64(opts.Llama3LowFreqFactor)
+	highFreqWavelen := origCtx / float64(opts.Llama3HighFreqFactor)
+
+	factors := make([]float32, n)
+	for i := range factors {
+		freq := math.Pow(float64(base), -float64(2*i)/float64(dim))
+		wavelen := 2 * math.Pi / freq
+
+		switch {
+		case wavelen < highFreqWavelen:
+			factors[i] = 1
+		case wavelen > lowFreqWavelen:
+			factors[i] = 1 / opts.ScalingFactor
+		default:
+			smooth := (origCtx/wavelen - float64(opts.Llama3LowFreqFactor)) /
+				float64(opts.Llama3HighFreqFactor-opts.Llama3LowFreqFactor)
+			factors[i] = float32((1-smooth)/float64(opts.ScalingFactor) + smooth)
+		}
+	}
+	return factors
+}
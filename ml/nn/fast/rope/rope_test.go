@@ -0,0 +1,90 @@
+package rope
+
+import (
+	"math"
+	"testing"
+)
+
+func TestYarnFrequencies(t *testing.T) {
+	opts := Options{
+		ScalingType:           ScalingYaRN,
+		OriginalContextLength: 2048,
+		YarnBetaFast:          32,
+		YarnBetaSlow:          1,
+		ScalingFactor:         2,
+	}
+
+	// Low-index (high-frequency) dimensions should stay near unscaled
+	// (extrapolated); high-index (low-frequency) dimensions should ramp
+	// down toward 1/ScalingFactor (interpolated).
+	want := []float32{1, 1, 0.75, 0.5}
+	got := opts.Frequencies(8, 10000)
+	if len(got) != len(want) {
+		t.Fatalf("len(got) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if diff := math.Abs(float64(got[i] - want[i])); diff > 1e-6 {
+			t.Errorf("factors[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLinearFrequencies(t *testing.T) {
+	opts := Options{
+		ScalingType:   ScalingLinear,
+		ScalingFactor: 2,
+	}
+
+	want := []float32{0.5, 0.5, 0.5, 0.5}
+	got := opts.Frequencies(8, 10000)
+	if len(got) != len(want) {
+		t.Fatalf("len(got) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if diff := math.Abs(float64(got[i] - want[i])); diff > 1e-6 {
+			t.Errorf("factors[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNTKFrequencies(t *testing.T) {
+	opts := Options{
+		ScalingType:   ScalingNTK,
+		ScalingFactor: 2,
+	}
+
+	// NTK raises the effective base so low-index (high-frequency)
+	// dimensions stay unscaled while high-index (low-frequency)
+	// dimensions ramp down toward 1/ScalingFactor.
+	want := []float32{1, 0.7937005, 0.6299605, 0.5}
+	got := opts.Frequencies(8, 10000)
+	if len(got) != len(want) {
+		t.Fatalf("len(got) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if diff := math.Abs(float64(got[i] - want[i])); diff > 1e-6 {
+			t.Errorf("factors[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLlama3Frequencies(t *testing.T) {
+	opts := Options{
+		ScalingType:           ScalingLlama3,
+		OriginalContextLength: 8192,
+		ScalingFactor:         8,
+		Llama3LowFreqFactor:   1,
+		Llama3HighFreqFactor:  4,
+	}
+
+	want := []float32{1, 1, 1, 0.21360754}
+	got := opts.Frequencies(8, 10000)
+	if len(got) != len(want) {
+		t.Fatalf("len(got) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if diff := math.Abs(float64(got[i] - want[i])); diff > 1e-6 {
+			t.Errorf("factors[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package blob implements a content-addressable cache of blobs on disk,
+// keyed by their digest, plus a set of named refs (tags) pointing at them.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Digest is a content hash identifying a blob, formatted as "<type>:<sum>",
+// e.g. "sha256:ba7816bf...".
+type Digest struct {
+	Type string
+	Sum  string
+}
+
+// ParseDigest parses a digest string of the form "<type>:<sum>".
+func ParseDigest(s string) (Digest, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return Digest{}, fmt.Errorf("blob: invalid digest %q", s)
+	}
+	return Digest{Type: s[:i], Sum: s[i+1:]}, nil
+}
+
+func (d Digest) String() string {
+	return d.Type + ":" + d.Sum
+}
+
+// Short returns a shortened form of d suitable for logging.
+func (d Digest) Short() string {
+	if len(d.Sum) <= 12 {
+		return d.String()
+	}
+	return d.Type + ":" + d.Sum[:12]
+}
+
+func (d Digest) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Digest) UnmarshalText(b []byte) error {
+	pd, err := ParseDigest(string(b))
+	if err != nil {
+		return err
+	}
+	*d = pd
+	return nil
+}
+
+// DiskCache is a content-addressable store of blobs and named refs backed
+// by a directory on disk.
+type DiskCache struct {
+	dir string
+}
+
+// Open opens the cache rooted at dir, creating it if it doesn't exist.
+func Open(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "refs"), 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (c *DiskCache) blobPath(d Digest) string {
+	return filepath.Join(c.dir, "blobs", d.Type+"-"+d.Sum)
+}
+
+func (c *DiskCache) refPath(name string) string {
+	return filepath.Join(c.dir, "refs", url.PathEscape(name))
+}
+
+// Put writes size bytes read from r to the cache under d, verifying that
+// their sha256 sum matches d.Sum before making the blob visible to Get.
+func (c *DiskCache) Put(d Digest, r io.Reader, size int64) error {
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, "blobs"), "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, size)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if d.Type == "sha256" {
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != d.Sum {
+			return fmt.Errorf("blob: digest mismatch: got sha256:%s, want %s", sum, d.String())
+		}
+	}
+
+	return os.Rename(tmp.Name(), c.blobPath(d))
+}
+
+// Get opens the blob stored under d. It returns an error satisfying
+// errors.Is(err, fs.ErrNotExist) if no such blob has been Put.
+func (c *DiskCache) Get(d Digest) (*os.File, error) {
+	return os.Open(c.blobPath(d))
+}
+
+// Link records that name (typically a fully-qualified "host/path:tag"
+// reference) resolves to d.
+func (c *DiskCache) Link(name string, d Digest) error {
+	return os.WriteFile(c.refPath(name), []byte(d.String()), 0o644)
+}
+
+// Resolve returns the digest name was last Linked to. It returns an error
+// satisfying errors.Is(err, fs.ErrNotExist) if name has never been linked.
+func (c *DiskCache) Resolve(name string) (Digest, error) {
+	b, err := os.ReadFile(c.refPath(name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Digest{}, fs.ErrNotExist
+		}
+		return Digest{}, err
+	}
+	return ParseDigest(string(b))
+}
@@ -0,0 +1,416 @@
+package ollama
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/server/internal/cache/blob"
+)
+
+// pushChunkSize is the size of each chunk uploaded via PATCH once a blob
+// exceeds ChunkingThreshold. Pull's range sizes, by contrast, are whatever
+// the registry's chunksums response lists. It is a var, not a const, so
+// tests can shrink it.
+var pushChunkSize int64 = 64 << 20 // 64 MiB
+
+// Push uploads the manifest and any missing layers for name to the
+// registry, mirroring Pull's design in reverse: small blobs are uploaded
+// in a single monolithic POST, and blobs larger than r.ChunkingThreshold
+// are split into pushChunkSize ranges uploaded via an OCI-style POST
+// .../blobs/uploads/ session followed by a sequence of PATCH requests and
+// a final PUT ?digest=... to commit. Per the OCI resumable-upload
+// protocol, chunks are PATCHed sequentially, each one addressed to the
+// Location the previous PATCH (or the initial POST) returned; the
+// protocol gives no way to PATCH a session out of order or concurrently.
+//
+// Trace.Update, if present on ctx (see WithTrace), is called as each
+// blob's upload progresses, and with ErrCached (n set to the blob's full
+// size) for any blob the registry reports it already has via HEAD,
+// mirroring how Pull reports cache hits.
+func (r *Registry) Push(ctx context.Context, name string) error {
+	ref, err := parseRef(name)
+	if err != nil {
+		return err
+	}
+
+	m, err := r.localManifest(ref)
+	if err != nil {
+		return err
+	}
+
+	t := traceFromContext(ctx)
+
+	blobs := m.Layers
+	if m.Config != nil {
+		blobs = append(blobs, *m.Config)
+	}
+
+	g, ctx := errgroupWithConcurrency(ctx, r.maxStreams())
+	for _, l := range blobs {
+		g.Go(func() error {
+			return r.pushLayer(ctx, ref, l, t)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	return r.putManifest(ctx, ref, m)
+}
+
+// ref identifies a manifest reference to push to: the registry host, the
+// repository path (without a leading /v2 or trailing tag), and the tag.
+type ref struct {
+	scheme, host, path, tag string
+}
+
+// parseRef splits a Push name such as "https://o.com/library/abc:v2" into
+// its host, repository path, and tag, defaulting tag to "latest" as Pull
+// does when a reference omits one.
+func parseRef(name string) (ref, error) {
+	u, err := url.Parse(name)
+	if err != nil {
+		return ref{}, err
+	}
+
+	path, tag := u.Path, "latest"
+	if i := strings.LastIndexByte(path, ':'); i >= 0 {
+		tag = path[i+1:]
+		path = path[:i]
+	}
+
+	return ref{scheme: u.Scheme, host: u.Host, path: path, tag: tag}, nil
+}
+
+// url returns the /v2/<path>/<rest> endpoint URL for r.
+func (r ref) url(rest string) string {
+	return fmt.Sprintf("%s://%s/v2%s/%s", r.scheme, r.host, r.path, rest)
+}
+
+// manifestTag returns the local cache key Pull uses to record the
+// manifest digest for a fully-resolved reference (host + path + tag).
+func (r ref) manifestTag() string {
+	return r.host + r.path + ":" + r.tag
+}
+
+// localManifest reads the manifest previously written to the local cache
+// for ref (e.g. by a prior pull, or by the caller assembling a new
+// model), resolving it the same way Pull records completed pulls for
+// later reuse.
+func (r *Registry) localManifest(ref ref) (*Manifest, error) {
+	d, err := r.Cache.Resolve(ref.manifestTag())
+	if err != nil {
+		return nil, err
+	}
+	f, err := r.Cache.Get(d)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m Manifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// pushLayer uploads a single blob, skipping the transfer entirely if the
+// registry already reports having it.
+func (r *Registry) pushLayer(ctx context.Context, ref ref, l Layer, t *Trace) error {
+	exists, err := r.blobExists(ctx, ref, l.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		t.update(&l, l.Size, ErrCached)
+		return nil
+	}
+
+	f, err := r.Cache.Get(l.Digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if l.Size <= r.chunkingThreshold() {
+		return r.pushMonolithic(ctx, ref, l, f, t)
+	}
+	return r.pushChunked(ctx, ref, l, f, t)
+}
+
+// pushMonolithic uploads a blob in a single POST .../blobs/uploads/?digest=...
+// request, the OCI shortcut for blobs too small to bother chunking.
+func (r *Registry) pushMonolithic(ctx context.Context, ref ref, l Layer, body io.ReadSeeker, t *Trace) error {
+	dst := ref.url("blobs/uploads/?digest=" + l.Digest.String())
+	req, err := http.NewRequestWithContext(ctx, "POST", dst, io.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = l.Size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := r.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := checkUploadResponse(res); err != nil {
+		return err
+	}
+	t.update(&l, l.Size, nil)
+	return nil
+}
+
+// pushChunked uploads a blob as a POST .../blobs/uploads/ session followed
+// by a sequence of PATCH requests, each carrying a Content-Range and sent
+// to the Location the previous request returned, before a final PUT
+// ?digest=... commits the upload. Per the OCI resumable-upload protocol,
+// chunks are sent one at a time: the Location for chunk N+1 is only known
+// once chunk N's response arrives, so chunks cannot be parallelized
+// within a single blob. A chunk that fails retries with exponential
+// backoff and resumes from the offset reported in the failure's Range
+// header rather than resending bytes the registry already has.
+func (r *Registry) pushChunked(ctx context.Context, ref ref, l Layer, body io.ReadSeeker, t *Trace) error {
+	loc, err := r.startUpload(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	for start := int64(0); start < l.Size; start += pushChunkSize {
+		end := min(start+pushChunkSize, l.Size)
+		next, err := r.pushChunkWithRetry(ctx, loc, body, start, end, l, t)
+		if err != nil {
+			return err
+		}
+		loc = next
+	}
+
+	return r.finishUpload(ctx, loc, l.Digest)
+}
+
+// pushChunkWithRetry uploads the [start, end) byte range of body, retrying
+// with exponential backoff on transient failures. On each attempt it
+// resumes from the offset the registry last acknowledged for this chunk
+// (via the failed response's Range header) rather than restarting the
+// range. It returns the Location the next chunk (or the final commit PUT,
+// if this was the last chunk) should be sent to.
+//
+// The chunk is buffered so its sha256 can be computed and sent in the
+// X-Content-SHA256 header of every attempt, for the registry to check
+// before it acknowledges the chunk. The client itself does not compare
+// the sum against anything; the header always carries the whole chunk's
+// sum, also on a resumed attempt that sends only the chunk's remainder.
+func (r *Registry) pushChunkWithRetry(ctx context.Context, loc string, body io.ReadSeeker, start, end int64, l Layer, t *Trace) (string, error) {
+	buf := make([]byte, end-start)
+	if _, err := body.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(body, buf); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	digest := hex.EncodeToString(sum[:])
+
+	const maxAttempts = 5
+	backoff := 100 * time.Millisecond
+	resumeFrom := int64(0)
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "PATCH", loc, io.NopCloser(bytes.NewReader(buf[resumeFrom:])))
+		if err != nil {
+			return "", err
+		}
+		req.ContentLength = int64(len(buf)) - resumeFrom
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", start+resumeFrom, end-1))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("X-Content-SHA256", "sha256:"+digest)
+
+		res, err := r.httpClient().Do(req)
+		if err == nil {
+			cerr := checkUploadResponse(res)
+			if cerr == nil {
+				next := resolveRelativeTo(loc, res.Header.Get("Location"))
+				res.Body.Close()
+				t.update(&l, end-start, nil)
+				return next, nil
+			}
+			err = cerr
+		}
+
+		off, ok := resumeOffset(res)
+		if res != nil {
+			res.Body.Close()
+		}
+		if attempt >= maxAttempts-1 || ctx.Err() != nil {
+			return "", fmt.Errorf("push chunk %d-%d: %w", start, end-1, err)
+		}
+		if ok {
+			resumeFrom = off - start
+		}
+		select {
+		case <-time.After(backoff + jitter(backoff)):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// resumeOffset extracts the byte offset the registry last acknowledged
+// from a failed PATCH response's Range header (e.g. "bytes=0-0"), per the
+// OCI resumable upload spec.
+func resumeOffset(res *http.Response) (int64, bool) {
+	if res == nil {
+		return 0, false
+	}
+	rng := res.Header.Get("Range")
+	i := strings.LastIndexByte(rng, '-')
+	if i < 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(rng[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n + 1, true
+}
+
+// startUpload begins a resumable upload session and returns the Location
+// the registry wants the first PATCH request sent to.
+func (r *Registry) startUpload(ctx context.Context, ref ref) (string, error) {
+	dst := ref.url("blobs/uploads/")
+	req, err := http.NewRequestWithContext(ctx, "POST", dst, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := r.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if err := checkUploadResponse(res); err != nil {
+		return "", err
+	}
+	loc := res.Header.Get("Location")
+	if loc == "" {
+		return "", fmt.Errorf("registry: upload session missing Location header")
+	}
+	return resolveLocation(ref, loc), nil
+}
+
+// resolveLocation makes loc, which per the OCI spec may be relative,
+// absolute against ref's scheme and host.
+func resolveLocation(ref ref, loc string) string {
+	if strings.Contains(loc, "://") {
+		return loc
+	}
+	return ref.scheme + "://" + ref.host + loc
+}
+
+// resolveRelativeTo makes loc, which per the OCI spec may be relative or
+// absent (meaning "unchanged"), absolute against base's scheme and host.
+func resolveRelativeTo(base, loc string) string {
+	switch {
+	case loc == "":
+		return base
+	case strings.Contains(loc, "://"):
+		return loc
+	}
+	if u, err := url.Parse(base); err == nil {
+		return u.Scheme + "://" + u.Host + loc
+	}
+	return loc
+}
+
+// finishUpload commits a chunked upload session, verifying digest against
+// what the registry recorded for the assembled blob.
+func (r *Registry) finishUpload(ctx context.Context, loc string, digest blob.Digest) error {
+	sep := "?"
+	if strings.Contains(loc, "?") {
+		sep = "&"
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", loc+sep+"digest="+digest.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return checkUploadResponse(res)
+}
+
+// blobExists reports whether the registry already has digest, via HEAD,
+// so Push can skip re-uploading blobs shared with a previous push.
+func (r *Registry) blobExists(ctx context.Context, ref ref, digest blob.Digest) (bool, error) {
+	dst := ref.url("blobs/" + digest.String())
+	req, err := http.NewRequestWithContext(ctx, "HEAD", dst, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := r.httpClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("registry: unexpected status checking blob %s: %s", digest.Short(), res.Status)
+	}
+}
+
+// putManifest uploads the manifest for ref after all of its layers have
+// been pushed.
+func (r *Registry) putManifest(ctx context.Context, ref ref, m *Manifest) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	dst := ref.url("manifests/" + ref.tag)
+	req, err := http.NewRequestWithContext(ctx, "PUT", dst, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	res, err := r.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return checkUploadResponse(res)
+}
+
+// checkUploadResponse maps a non-2xx upload response to an *Error decoded
+// from its body, mirroring how Pull surfaces registry errors.
+func checkUploadResponse(res *http.Response) error {
+	if res.StatusCode/100 == 2 {
+		return nil
+	}
+	var e struct {
+		Errors []Error `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&e); err == nil && len(e.Errors) > 0 {
+		return &e.Errors[0]
+	}
+	return fmt.Errorf("registry: unexpected status %s", res.Status)
+}
+
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(rand.Int64N(int64(d) + 1))
+}
@@ -0,0 +1,517 @@
+// Package ollama implements a client for the ollama registry.
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/server/internal/cache/blob"
+)
+
+// Registry is a client for the ollama registry's Pull and Push APIs.
+//
+// Scheduler and Limiter select the ChunkScheduler and RateLimiter Pull uses
+// when dispatching chunk range GETs; both default to FIFOScheduler and no
+// rate limiting when unset, so existing callers see no behavior change.
+type Registry struct {
+	Cache      *blob.DiskCache
+	HTTPClient *http.Client
+
+	// MaxStreams bounds the number of concurrent layer and chunk transfers
+	// Pull and Push perform. Zero means a small built-in default.
+	MaxStreams int
+
+	// ChunkingThreshold is the blob size above which Pull fetches (and
+	// Push uploads) a blob in chunks rather than as a single request.
+	// Zero means a built-in default.
+	ChunkingThreshold int64
+
+	// Scheduler assigns chunk range GETs to mirror URLs. Nil means
+	// FIFOScheduler.
+	Scheduler ChunkScheduler
+
+	// Limiter, if set, bounds the rate at which Pull reads chunk and
+	// blob bytes, per host.
+	Limiter RateLimiter
+}
+
+const (
+	defaultMaxStreams        = 4
+	defaultChunkingThreshold = 128 << 20
+)
+
+func (r *Registry) httpClient() *http.Client {
+	if r.HTTPClient != nil {
+		return r.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (r *Registry) maxStreams() int {
+	if r.MaxStreams > 0 {
+		return r.MaxStreams
+	}
+	return defaultMaxStreams
+}
+
+func (r *Registry) chunkingThreshold() int64 {
+	if r.ChunkingThreshold > 0 {
+		return r.ChunkingThreshold
+	}
+	return defaultChunkingThreshold
+}
+
+func (r *Registry) scheduler() ChunkScheduler {
+	if r.Scheduler != nil {
+		return r.Scheduler
+	}
+	return FIFOScheduler{}
+}
+
+// Manifest is a model manifest: its layers and, if present, its config
+// layer.
+type Manifest struct {
+	Layers []Layer `json:"layers"`
+	Config *Layer  `json:"config,omitempty"`
+}
+
+// Layer is a single content-addressed blob referenced by a Manifest.
+type Layer struct {
+	Digest blob.Digest `json:"digest"`
+	Size   int64       `json:"size"`
+}
+
+// Error is a registry API error, as returned in a response's "errors"
+// array.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("registry: %s", e.Code)
+	}
+	return fmt.Sprintf("registry: %s: %s", e.Code, e.Message)
+}
+
+// Is reports whether e represents the sentinel error target, so callers
+// can use errors.Is(err, ErrModelNotFound) instead of comparing codes.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrModelNotFound:
+		return e.Code == "MANIFEST_UNKNOWN" || e.Code == "NAME_UNKNOWN"
+	}
+	return false
+}
+
+var (
+	// ErrModelNotFound is returned by Pull when the registry has no
+	// manifest for the requested reference.
+	ErrModelNotFound = errors.New("model not found")
+	// ErrIncomplete is returned by Pull when a layer could not be fully
+	// downloaded: its chunksums stream failed to read or held a malformed
+	// line, or a chunk's range GET failed with a 5xx on every mirror the
+	// scheduler offered.
+	ErrIncomplete = errors.New("incomplete download")
+	// ErrCached is passed to Trace.Update, alongside the blob's full
+	// size, when Pull or Push finds the blob already present rather than
+	// transferring it.
+	ErrCached = errors.New("cached")
+)
+
+// Trace, if set on a context via WithTrace, is called as Pull and Push
+// transfer each blob.
+type Trace struct {
+	// Update is called once for every blob and chunk transfer that
+	// completes, reporting n bytes transferred (or already present, if
+	// err is ErrCached; otherwise err is nil). Failed attempts are not
+	// reported.
+	Update func(l *Layer, n int64, err error)
+}
+
+func (t *Trace) update(l *Layer, n int64, err error) {
+	if t == nil || t.Update == nil {
+		return
+	}
+	t.Update(l, n, err)
+}
+
+type traceKey struct{}
+
+// WithTrace returns a copy of ctx that Pull and Push will report progress
+// to via t.
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+func traceFromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// Pull fetches the manifest and any layers for name that aren't already in
+// the cache, verifies their digests, and links name to the resulting
+// manifest.
+//
+// Layers larger than r.ChunkingThreshold are fetched as a sequence of
+// range GETs, one per line of the layer's chunksums response, dispatched
+// through r.Scheduler (and, if set, throttled by r.Limiter) rather than as
+// a single request. Chunk and blob content already present in the cache
+// (by digest) is not re-fetched.
+func (r *Registry) Pull(ctx context.Context, name string) error {
+	ref, err := parseRef(name)
+	if err != nil {
+		return err
+	}
+
+	t := traceFromContext(ctx)
+
+	m, body, err := r.fetchManifest(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	layers := m.Layers
+	if m.Config != nil {
+		layers = append(layers, *m.Config)
+	}
+
+	g, ctx := errgroupWithConcurrency(ctx, r.maxStreams())
+	for _, l := range layers {
+		g.Go(func() error {
+			return r.pullLayer(ctx, ref, l, t)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(body)
+	d := blob.Digest{Type: "sha256", Sum: hex.EncodeToString(sum[:])}
+	if err := r.Cache.Put(d, bytes.NewReader(body), int64(len(body))); err != nil {
+		return err
+	}
+	return r.Cache.Link(ref.manifestTag(), d)
+}
+
+// fetchManifest retrieves and decodes ref's manifest, returning both the
+// decoded Manifest and its raw bytes, since the raw bytes (not a
+// re-marshaled copy) are what gets hashed and cached as ref's manifest
+// blob.
+func (r *Registry) fetchManifest(ctx context.Context, ref ref) (*Manifest, []byte, error) {
+	dst := ref.url("manifests/" + ref.tag)
+	req, err := http.NewRequestWithContext(ctx, "GET", dst, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := r.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if res.StatusCode/100 != 2 {
+		return nil, nil, decodeRegistryErrorBody(body, res.Status)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, nil, err
+	}
+	return &m, body, nil
+}
+
+// pullLayer fetches a single layer's blob, skipping the transfer entirely
+// if it's already present in the cache.
+func (r *Registry) pullLayer(ctx context.Context, ref ref, l Layer, t *Trace) error {
+	if f, err := r.Cache.Get(l.Digest); err == nil {
+		f.Close()
+		t.update(&l, l.Size, ErrCached)
+		return nil
+	}
+
+	if l.Size <= r.chunkingThreshold() {
+		return r.pullMonolithic(ctx, ref, l, t)
+	}
+	return r.pullChunked(ctx, ref, l, t)
+}
+
+// pullMonolithic fetches an entire blob in a single GET.
+func (r *Registry) pullMonolithic(ctx context.Context, ref ref, l Layer, t *Trace) error {
+	dst := ref.url("blobs/" + l.Digest.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", dst, nil)
+	if err != nil {
+		return err
+	}
+
+	if r.Limiter != nil {
+		if err := r.Limiter.WaitN(ctx, req.URL.Host, int(l.Size)); err != nil {
+			return err
+		}
+	}
+
+	res, err := r.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return decodeRegistryError(res)
+	}
+
+	if err := r.Cache.Put(l.Digest, res.Body, l.Size); err != nil {
+		return err
+	}
+	t.update(&l, l.Size, nil)
+	return nil
+}
+
+// pullChunked fetches a blob as a sequence of range GETs, one per chunk
+// listed in its chunksums response, dispatched concurrently (bounded by
+// r.MaxStreams) as each chunksum line is parsed rather than after reading
+// the whole response, so a slow chunksums stream doesn't delay chunks that
+// have already been described.
+func (r *Registry) pullChunked(ctx context.Context, ref ref, l Layer, t *Trace) error {
+	dst := ref.url("chunksums/" + l.Digest.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", dst, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return decodeRegistryError(res)
+	}
+
+	mirrors := res.Header.Values("Content-Location")
+	if len(mirrors) == 0 {
+		mirrors = []string{ref.url("blobs/" + l.Digest.String())}
+	}
+
+	sched := r.scheduler()
+	g, ctx := errgroupWithConcurrency(ctx, r.maxStreams())
+
+	var digests []blob.Digest
+
+	var lineErr error
+	sc := bufio.NewScanner(res.Body)
+	for sc.Scan() {
+		d, rng, perr := parseChunksumLine(sc.Text())
+		if perr != nil {
+			lineErr = perr
+			break
+		}
+
+		digests = append(digests, d)
+
+		chunk := Chunk{LayerDigest: l.Digest, Range: rng, MirrorURLs: mirrors}
+		url := sched.Assign(chunk)
+		g.Go(func() error {
+			return r.pullChunk(ctx, l, d, rng, url, mirrors, sched, t)
+		})
+	}
+	if lineErr == nil {
+		lineErr = sc.Err()
+	}
+
+	waitErr := g.Wait()
+	if lineErr != nil {
+		return fmt.Errorf("%w: %w", ErrIncomplete, lineErr)
+	}
+	if waitErr != nil {
+		return waitErr
+	}
+
+	return r.assembleChunkedBlob(l, digests)
+}
+
+// pullChunk fetches a single byte range of layerDigest into the cache,
+// keyed by the chunk's own digest, skipping the transfer if that chunk is
+// already cached from a previous, interrupted pull. On a 5xx response it
+// asks sched for an alternate mirror, and gives up with ErrIncomplete once
+// sched offers no mirror this chunk has not already tried.
+func (r *Registry) pullChunk(ctx context.Context, l Layer, d blob.Digest, rng ChunkRange, url string, mirrors []string, sched ChunkScheduler, t *Trace) error {
+	if f, err := r.Cache.Get(d); err == nil {
+		f.Close()
+		t.update(&l, rng.End-rng.Start+1, ErrCached)
+		return nil
+	}
+
+	tried := map[string]bool{url: true}
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", rng.Start, rng.End))
+
+		if r.Limiter != nil {
+			if err := r.Limiter.WaitN(ctx, req.URL.Host, int(rng.End-rng.Start+1)); err != nil {
+				return err
+			}
+		}
+
+		res, err := r.httpClient().Do(req)
+		if err == nil && res.StatusCode/100 == 2 {
+			defer res.Body.Close()
+			n := rng.End - rng.Start + 1
+			if err := r.Cache.Put(d, res.Body, n); err != nil {
+				return err
+			}
+			t.update(&l, n, nil)
+			return nil
+		}
+
+		if err == nil && res.StatusCode/100 == 5 {
+			if next := sched.Retry(url, mirrors); !tried[next] {
+				tried[next] = true
+				res.Body.Close()
+				url = next
+				continue
+			}
+			defer res.Body.Close()
+			return fmt.Errorf("%w: %w", ErrIncomplete, decodeRegistryError(res))
+		}
+
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		return decodeRegistryError(res)
+	}
+}
+
+// assembleChunkedBlob concatenates a layer's chunks, in order, from the
+// cache into a single blob addressable by the layer's own digest.
+func (r *Registry) assembleChunkedBlob(l Layer, digests []blob.Digest) error {
+	readers := make([]io.Reader, len(digests))
+	var closers []io.Closer
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+
+	for i, d := range digests {
+		f, err := r.Cache.Get(d)
+		if err != nil {
+			return err
+		}
+		closers = append(closers, f)
+		readers[i] = f
+	}
+
+	return r.Cache.Put(l.Digest, io.MultiReader(readers...), l.Size)
+}
+
+// parseChunksumLine parses a single line of a chunksums response, formatted
+// as "<digest> <start>-<end>" with an inclusive byte range.
+func parseChunksumLine(line string) (blob.Digest, ChunkRange, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return blob.Digest{}, ChunkRange{}, fmt.Errorf("registry: malformed chunksum line %q", line)
+	}
+
+	d, err := blob.ParseDigest(fields[0])
+	if err != nil {
+		return blob.Digest{}, ChunkRange{}, err
+	}
+
+	i := strings.IndexByte(fields[1], '-')
+	if i < 0 {
+		return blob.Digest{}, ChunkRange{}, fmt.Errorf("registry: malformed chunksum range %q", fields[1])
+	}
+	start, err := strconv.ParseInt(fields[1][:i], 10, 64)
+	if err != nil {
+		return blob.Digest{}, ChunkRange{}, err
+	}
+	end, err := strconv.ParseInt(fields[1][i+1:], 10, 64)
+	if err != nil {
+		return blob.Digest{}, ChunkRange{}, err
+	}
+	return d, ChunkRange{Start: start, End: end}, nil
+}
+
+// decodeRegistryError decodes a non-2xx response body into an *Error,
+// falling back to a generic error describing the status if the body isn't
+// a registry error payload.
+func decodeRegistryError(res *http.Response) error {
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("registry: unexpected status %s", res.Status)
+	}
+	return decodeRegistryErrorBody(body, res.Status)
+}
+
+func decodeRegistryErrorBody(body []byte, status string) error {
+	var e struct {
+		Errors []Error `json:"errors"`
+	}
+	if json.Unmarshal(body, &e) == nil && len(e.Errors) > 0 {
+		return &e.Errors[0]
+	}
+	return fmt.Errorf("registry: unexpected status %s", status)
+}
+
+// boundedGroup runs functions concurrently, bounded to n at a time,
+// collecting the first error returned. Unlike errgroup.WithContext, it
+// does not cancel its context when a function fails, so work already
+// dispatched (e.g. other chunks of the same layer) still runs to
+// completion and reports accurate progress via Trace.Update.
+type boundedGroup struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func errgroupWithConcurrency(ctx context.Context, n int) (*boundedGroup, context.Context) {
+	if n <= 0 {
+		n = 1
+	}
+	return &boundedGroup{sem: make(chan struct{}, n)}, ctx
+}
+
+func (g *boundedGroup) Go(fn func() error) {
+	g.sem <- struct{}{}
+	g.wg.Add(1)
+	go func() {
+		defer func() {
+			<-g.sem
+			g.wg.Done()
+		}()
+		if err := fn(); err != nil {
+			g.mu.Lock()
+			if g.err == nil {
+				g.err = err
+			}
+			g.mu.Unlock()
+		}
+	}()
+}
+
+func (g *boundedGroup) Wait() error {
+	g.wg.Wait()
+	return g.err
+}
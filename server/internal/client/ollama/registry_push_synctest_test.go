@@ -0,0 +1,300 @@
+//go:build goexperiment.synctest
+
+package ollama
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ollama/ollama/server/internal/cache/blob"
+	"github.com/ollama/ollama/server/internal/testutil"
+)
+
+// seedPushCache writes content into c.Cache as a single-layer manifest and
+// links it under "o.com/library/abc:"+tag, mirroring the state a prior
+// successful Pull (or a freshly built model) would leave behind.
+func seedPushCache(t *testing.T, c *Registry, content, tag string) error {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(content))
+	d := blob.Digest{Type: "sha256", Sum: hex.EncodeToString(sum[:])}
+
+	if err := c.Cache.Put(d, bytes.NewReader([]byte(content)), int64(len(content))); err != nil {
+		return err
+	}
+
+	m := Manifest{Layers: []Layer{{Digest: d, Size: int64(len(content))}}}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	msum := sha256.Sum256(body)
+	md := blob.Digest{Type: "sha256", Sum: hex.EncodeToString(msum[:])}
+	if err := c.Cache.Put(md, bytes.NewReader(body), int64(len(body))); err != nil {
+		return err
+	}
+	return c.Cache.Link("o.com/library/abc:"+tag, md)
+}
+
+func TestPushSimple(t *testing.T) {
+	var steps atomic.Int64
+	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch steps.Add(1) {
+		case 1:
+			checkRequest(t, r, "HEAD", "/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			w.WriteHeader(http.StatusNotFound)
+		case 2:
+			checkRequest(t, r, "POST", "/v2/library/abc/blobs/uploads/")
+			if got := r.URL.Query().Get("digest"); got != "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+				t.Errorf("digest = %q", got)
+			}
+			body, _ := io.ReadAll(r.Body)
+			if string(body) != "abc" {
+				t.Errorf("body = %q, want %q", body, "abc")
+			}
+		case 3:
+			checkRequest(t, r, "PUT", "/v2/library/abc/manifests/latest")
+		default:
+			t.Errorf("unexpected step %d: %v", steps.Load(), r)
+		}
+	})
+
+	if err := seedPushCache(t, c, "abc", "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Push(ctx, "http://o.com/library/abc"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+}
+
+func TestPushPreservesTag(t *testing.T) {
+	var steps atomic.Int64
+	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch steps.Add(1) {
+		case 1:
+			checkRequest(t, r, "HEAD", "/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			w.WriteHeader(http.StatusNotFound)
+		case 2:
+			checkRequest(t, r, "POST", "/v2/library/abc/blobs/uploads/")
+		case 3:
+			// The pushed reference names tag "v2"; the manifest must be
+			// published there, not silently to "latest".
+			checkRequest(t, r, "PUT", "/v2/library/abc/manifests/v2")
+		default:
+			t.Errorf("unexpected step %d: %v", steps.Load(), r)
+		}
+	})
+
+	if err := seedPushCache(t, c, "abc", "v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Push(ctx, "http://o.com/library/abc:v2"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+}
+
+func TestPushChunked(t *testing.T) {
+	var steps atomic.Int64
+	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch steps.Add(1) {
+		case 1:
+			checkRequest(t, r, "HEAD", "/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			w.WriteHeader(http.StatusNotFound)
+		case 2:
+			checkRequest(t, r, "POST", "/v2/library/abc/blobs/uploads/")
+			w.Header().Set("Location", "/v2/library/abc/blobs/uploads/session-1")
+			w.WriteHeader(http.StatusAccepted)
+		case 3:
+			checkRequest(t, r, "PATCH", "/v2/library/abc/blobs/uploads/session-1")
+			if rng := r.Header.Get("Content-Range"); rng != "0-2" {
+				t.Errorf("Content-Range = %q, want %q", rng, "0-2")
+			}
+			// The chunk's sha256 must be computed and offered for the
+			// registry to verify, per-chunk, before it's acknowledged.
+			if got := r.Header.Get("X-Content-SHA256"); got != "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+				t.Errorf("X-Content-SHA256 = %q, want the chunk's digest", got)
+			}
+		case 4:
+			checkRequest(t, r, "PUT", "/v2/library/abc/blobs/uploads/session-1")
+			if got := r.URL.Query().Get("digest"); got != "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+				t.Errorf("digest = %q", got)
+			}
+		case 5:
+			checkRequest(t, r, "PUT", "/v2/library/abc/manifests/latest")
+		default:
+			t.Errorf("unexpected step %d: %v", steps.Load(), r)
+		}
+	})
+
+	c.ChunkingThreshold = 1 // force chunking
+
+	if err := seedPushCache(t, c, "abc", "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.Push(ctx, "http://o.com/library/abc")
+	testutil.Check(t, err)
+}
+
+// TestPushChunkedMultiChunk forces a blob to split into more than one
+// PATCH and asserts each chunk is sent sequentially to the Location the
+// previous request returned, per the OCI resumable-upload protocol,
+// rather than concurrently against the original session URL.
+func TestPushChunkedMultiChunk(t *testing.T) {
+	old := pushChunkSize
+	pushChunkSize = 1 // one byte per chunk
+	t.Cleanup(func() { pushChunkSize = old })
+
+	var steps atomic.Int64
+	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch steps.Add(1) {
+		case 1:
+			checkRequest(t, r, "HEAD", "/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			w.WriteHeader(http.StatusNotFound)
+		case 2:
+			checkRequest(t, r, "POST", "/v2/library/abc/blobs/uploads/")
+			w.Header().Set("Location", "/v2/library/abc/blobs/uploads/session-1")
+			w.WriteHeader(http.StatusAccepted)
+		case 3:
+			// First chunk goes to the session URL from the POST.
+			checkRequest(t, r, "PATCH", "/v2/library/abc/blobs/uploads/session-1")
+			if rng := r.Header.Get("Content-Range"); rng != "0-0" {
+				t.Errorf("Content-Range = %q, want %q", rng, "0-0")
+			}
+			w.Header().Set("Location", "/v2/library/abc/blobs/uploads/session-1?_state=aaa")
+		case 4:
+			// Second chunk must go to the Location the first PATCH
+			// returned, not back to the original session URL.
+			checkRequest(t, r, "PATCH", "/v2/library/abc/blobs/uploads/session-1")
+			if got := r.URL.RawQuery; got != "_state=aaa" {
+				t.Errorf("second PATCH query = %q, want %q (should chain Location)", got, "_state=aaa")
+			}
+			if rng := r.Header.Get("Content-Range"); rng != "1-1" {
+				t.Errorf("Content-Range = %q, want %q", rng, "1-1")
+			}
+			w.Header().Set("Location", "/v2/library/abc/blobs/uploads/session-1?_state=bbb")
+		case 5:
+			checkRequest(t, r, "PATCH", "/v2/library/abc/blobs/uploads/session-1")
+			if got := r.URL.RawQuery; got != "_state=bbb" {
+				t.Errorf("third PATCH query = %q, want %q (should chain Location)", got, "_state=bbb")
+			}
+			if rng := r.Header.Get("Content-Range"); rng != "2-2" {
+				t.Errorf("Content-Range = %q, want %q", rng, "2-2")
+			}
+		case 6:
+			checkRequest(t, r, "PUT", "/v2/library/abc/blobs/uploads/session-1")
+		case 7:
+			checkRequest(t, r, "PUT", "/v2/library/abc/manifests/latest")
+		default:
+			t.Errorf("unexpected step %d: %v", steps.Load(), r)
+		}
+	})
+	c.ChunkingThreshold = 1 // force chunking
+
+	if err := seedPushCache(t, c, "abc", "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Push(ctx, "http://o.com/library/abc"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+}
+
+func TestPushResumeAfterError(t *testing.T) {
+	var steps atomic.Int64
+	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch steps.Add(1) {
+		case 1:
+			checkRequest(t, r, "HEAD", "/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			w.WriteHeader(http.StatusNotFound)
+		case 2:
+			checkRequest(t, r, "POST", "/v2/library/abc/blobs/uploads/")
+			w.Header().Set("Location", "/v2/library/abc/blobs/uploads/session-1")
+			w.WriteHeader(http.StatusAccepted)
+		case 3:
+			// First chunk attempt fails partway; registry reports it
+			// only received the first byte, so the retry should resume
+			// from offset 1 rather than resending the whole range.
+			checkRequest(t, r, "PATCH", "/v2/library/abc/blobs/uploads/session-1")
+			w.Header().Set("Range", "bytes=0-0")
+			w.WriteHeader(http.StatusInternalServerError)
+		case 4:
+			checkRequest(t, r, "PATCH", "/v2/library/abc/blobs/uploads/session-1")
+			if rng := r.Header.Get("Content-Range"); rng != "1-2" {
+				t.Errorf("Content-Range = %q, want %q (should resume, not restart)", rng, "1-2")
+			}
+		case 5:
+			checkRequest(t, r, "PUT", "/v2/library/abc/blobs/uploads/session-1")
+		case 6:
+			checkRequest(t, r, "PUT", "/v2/library/abc/manifests/latest")
+		default:
+			t.Errorf("unexpected step %d: %v", steps.Load(), r)
+		}
+	})
+
+	c.ChunkingThreshold = 1 // force chunking
+
+	if err := seedPushCache(t, c, "abc", "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Push(ctx, "http://o.com/library/abc"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+}
+
+func TestPushTracksOnlyCommittedBytes(t *testing.T) {
+	var steps atomic.Int64
+	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch steps.Add(1) {
+		case 1:
+			checkRequest(t, r, "HEAD", "/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			w.WriteHeader(http.StatusNotFound)
+		case 2:
+			checkRequest(t, r, "POST", "/v2/library/abc/blobs/uploads/")
+			w.Header().Set("Location", "/v2/library/abc/blobs/uploads/session-1")
+			w.WriteHeader(http.StatusAccepted)
+		case 3:
+			// Fails; the trace must not count these 3 bytes as uploaded.
+			w.WriteHeader(http.StatusInternalServerError)
+		case 4:
+			io.ReadAll(r.Body)
+		case 5:
+			checkRequest(t, r, "PUT", "/v2/library/abc/blobs/uploads/session-1")
+		case 6:
+			checkRequest(t, r, "PUT", "/v2/library/abc/manifests/latest")
+		default:
+			t.Errorf("unexpected step %d: %v", steps.Load(), r)
+		}
+	})
+
+	c.ChunkingThreshold = 1 // force chunking
+
+	if err := seedPushCache(t, c, "abc", "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploaded int64
+	ctx = WithTrace(ctx, &Trace{
+		Update: func(l *Layer, n int64, err error) {
+			if err == nil {
+				uploaded += n
+			}
+		},
+	})
+
+	if err := c.Push(ctx, "http://o.com/library/abc"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if uploaded != 3 {
+		t.Fatalf("uploaded = %d, want 3 (the failed attempt must not be double-counted)", uploaded)
+	}
+}
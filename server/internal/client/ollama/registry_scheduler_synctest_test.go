@@ -0,0 +1,177 @@
+//go:build goexperiment.synctest
+
+package ollama
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/server/internal/testutil"
+)
+
+func TestLeastLoadedSchedulerSpreadsAcrossMirrors(t *testing.T) {
+	var s LeastLoadedScheduler
+
+	mirrors := []string{"http://mirror-a", "http://mirror-b"}
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		counts[s.Assign(Chunk{MirrorURLs: mirrors})]++
+	}
+	if counts[mirrors[0]] != 2 || counts[mirrors[1]] != 2 {
+		t.Fatalf("counts = %v, want an even split across %v", counts, mirrors)
+	}
+}
+
+func TestLeastLoadedSchedulerRetriesOnAlternateMirror(t *testing.T) {
+	var s LeastLoadedScheduler
+
+	mirrors := []string{"http://origin", "http://mirror-1", "http://mirror-2"}
+	chunk := Chunk{MirrorURLs: mirrors}
+
+	assigned := s.Assign(chunk)
+	if assigned != mirrors[0] {
+		t.Fatalf("initial assignment = %q, want %q", assigned, mirrors[0])
+	}
+
+	// Simulate the assigned mirror returning a 5xx: the retry must land on
+	// a different mirror rather than hammering the one that just failed.
+	retry := s.Retry(assigned, mirrors)
+	if retry == assigned {
+		t.Fatalf("Retry returned the same failing mirror %q", retry)
+	}
+
+	// A further failure on every remaining mirror should still return a
+	// candidate distinct from the one that just failed, so a caller can
+	// exhaust all mirrors before surfacing ErrIncomplete.
+	retry2 := s.Retry(retry, mirrors)
+	if retry2 == retry {
+		t.Fatalf("second Retry returned the same failing mirror %q", retry2)
+	}
+}
+
+func TestLeastLoadedSchedulerRetrySingleMirror(t *testing.T) {
+	var s LeastLoadedScheduler
+	mirrors := []string{"http://only-mirror"}
+	got := s.Retry(mirrors[0], mirrors)
+	if got != mirrors[0] {
+		t.Fatalf("Retry with a single mirror = %q, want it unchanged: %q", got, mirrors[0])
+	}
+}
+
+func TestTokenBucketLimiterThrottles(t *testing.T) {
+	const rate = 100 // bytes/sec
+	l := NewTokenBucketLimiter(rate)
+
+	ctx := context.Background()
+
+	// Draining the initial burst should not block.
+	start := time.Now()
+	if err := l.WaitN(ctx, "host-a", rate); err != nil {
+		t.Fatalf("WaitN: %v", err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("initial burst blocked for %v, want ~0", d)
+	}
+
+	// A further request beyond the burst must wait roughly n/rate seconds.
+	start = time.Now()
+	if err := l.WaitN(ctx, "host-a", rate/2); err != nil {
+		t.Fatalf("WaitN: %v", err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("throttled request returned after %v, want >= ~500ms", d)
+	}
+}
+
+func TestTokenBucketLimiterPerHost(t *testing.T) {
+	const rate = 100 // bytes/sec
+	l := NewTokenBucketLimiter(rate)
+	ctx := context.Background()
+
+	// Exhaust host-a's bucket.
+	if err := l.WaitN(ctx, "host-a", rate); err != nil {
+		t.Fatalf("WaitN: %v", err)
+	}
+
+	// host-b has its own independent bucket and should not be throttled by
+	// host-a's usage.
+	start := time.Now()
+	if err := l.WaitN(ctx, "host-b", rate); err != nil {
+		t.Fatalf("WaitN: %v", err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("host-b blocked for %v by host-a's usage, want ~0", d)
+	}
+}
+
+func TestTokenBucketLimiterContextCanceled(t *testing.T) {
+	const rate = 1 // bytes/sec, so any real request blocks a long time
+	l := NewTokenBucketLimiter(rate)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := l.WaitN(ctx, "host-a", rate*10); err != context.Canceled {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestPullRedispatchesChunkToAlternateMirrorOn5xx drives a real Pull with a
+// LeastLoadedScheduler across two mirrors, one of which fails the chunk
+// range GET with a 5xx: Pull must retry on the other mirror rather than
+// surfacing ErrIncomplete.
+func TestPullRedispatchesChunkToAlternateMirrorOn5xx(t *testing.T) {
+	var step atomic.Int64
+	c, ctx := newRegistryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch step.Add(1) {
+		case 1:
+			checkRequest(t, r, "GET", "/v2/library/abc/manifests/latest")
+			io.WriteString(w, `{"layers":[{"size":3,"digest":"sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}]}`)
+		case 2:
+			checkRequest(t, r, "GET", "/v2/library/abc/chunksums/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			w.Header().Add("Content-Location", "http://mirror-a.internal/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			w.Header().Add("Content-Location", "http://mirror-b.internal/v2/library/abc/blobs/sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+			io.WriteString(w, "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad 0-2\n")
+		case 3:
+			if r.Host != "mirror-a.internal" {
+				t.Fatalf("first chunk attempt host = %q, want mirror-a.internal", r.Host)
+			}
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		case 4:
+			if r.Host != "mirror-b.internal" {
+				t.Fatalf("retried chunk attempt host = %q, want mirror-b.internal", r.Host)
+			}
+			if rng := r.Header.Get("Range"); rng != "bytes=0-2" {
+				t.Errorf("Range = %q, want %q", rng, "bytes=0-2")
+			}
+			io.WriteString(w, "abc")
+		default:
+			t.Errorf("unexpected steps %d: %v", step.Load(), r)
+			http.Error(w, "unexpected steps", http.StatusInternalServerError)
+		}
+	})
+
+	c.ChunkingThreshold = 1 // force chunking
+	c.Scheduler = &LeastLoadedScheduler{}
+
+	testutil.Check(t, c.Pull(ctx, "http://o.com/library/abc"))
+}
+
+// TestPullRateLimiterThrottlesBlobDownload drives a real Pull with a
+// RateLimiter set to well below the blob's size, and asserts Pull actually
+// takes as long as the limiter's budget implies, rather than the limiter
+// being configured but never consulted.
+func TestPullRateLimiterThrottlesBlobDownload(t *testing.T) {
+	c, ctx := newRegistryClient(t, handleSimplePull(t))
+	c.Limiter = NewTokenBucketLimiter(2) // 2 bytes/sec; blob is 3 bytes
+
+	start := time.Now()
+	testutil.Check(t, c.Pull(ctx, "http://o.com/library/abc"))
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("Pull returned after %v, want the rate limiter to have throttled it", d)
+	}
+}
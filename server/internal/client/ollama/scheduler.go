@@ -0,0 +1,219 @@
+package ollama
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/server/internal/cache/blob"
+)
+
+// ChunkRange is a single byte range of a layer's blob, as dispatched to a
+// worker slot by a ChunkScheduler.
+type ChunkRange struct {
+	Start, End int64 // inclusive, matching the HTTP Range semantics chunksums use
+}
+
+// Chunk is one unit of work Pull hands to a ChunkScheduler: a byte range
+// of layerDigest, along with any mirror URLs the chunksums response
+// advertised via repeated Content-Location headers, in the order they
+// were received.
+type Chunk struct {
+	LayerDigest blob.Digest
+	Range       ChunkRange
+	MirrorURLs  []string
+}
+
+// ChunkScheduler assigns chunks to worker slots. pullChunked calls Assign
+// once per chunk, as each chunksums line is parsed and streamed in, so
+// implementations that want to load-balance across a layer (e.g.
+// LeastLoadedScheduler) do so by keeping their own running state across
+// calls rather than by inspecting a batch handed to them at once.
+//
+// Implementations must be safe for concurrent use across layers within a
+// single Pull.
+type ChunkScheduler interface {
+	// Assign returns the mirror URL chunk should be fetched from.
+	Assign(chunk Chunk) string
+
+	// Retry records that url failed for a chunk whose candidate mirrors
+	// are candidates, and returns the next mirror Pull should try.
+	// Implementations that can't offer an alternative (e.g. FIFOScheduler)
+	// return url unchanged, telling the caller to give up and surface
+	// ErrIncomplete.
+	Retry(url string, candidates []string) string
+}
+
+// FIFOScheduler assigns every chunk to the first mirror URL advertised for
+// it (chunksums[0], typically the origin registry), preserving Pull's
+// historical behavior of dispatching chunks in arrival order bounded only
+// by MaxStreams. It never redirects a failed chunk to an alternate mirror.
+type FIFOScheduler struct{}
+
+func (FIFOScheduler) Assign(chunk Chunk) string {
+	if len(chunk.MirrorURLs) > 0 {
+		return chunk.MirrorURLs[0]
+	}
+	return ""
+}
+
+func (FIFOScheduler) Retry(url string, candidates []string) string {
+	return url
+}
+
+// LeastLoadedScheduler spreads chunks across the mirror URLs advertised
+// for their layer, always assigning the next chunk to whichever candidate
+// mirror currently has the fewest chunks assigned to it. This keeps a
+// single slow or flaky CDN edge from serializing an entire layer's
+// download behind it.
+type LeastLoadedScheduler struct {
+	mu    sync.Mutex
+	loads map[string]int
+}
+
+func (s *LeastLoadedScheduler) Assign(chunk Chunk) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loads == nil {
+		s.loads = make(map[string]int)
+	}
+
+	url := s.leastLoaded(chunk.MirrorURLs)
+	s.loads[url]++
+	return url
+}
+
+// leastLoaded returns the candidate with the smallest recorded load,
+// preferring earlier candidates (i.e. mirrors chunksums listed first) on
+// ties so behavior is deterministic when all mirrors are equally idle.
+func (s *LeastLoadedScheduler) leastLoaded(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if s.loads[c] < s.loads[best] {
+			best = c
+		}
+	}
+	return best
+}
+
+// Retry records that url failed for a chunk and returns the next mirror to
+// try from candidates, excluding url, again preferring the least loaded.
+// If url is the only candidate, Retry returns it unchanged, and the caller
+// should surface ErrIncomplete.
+func (s *LeastLoadedScheduler) Retry(url string, candidates []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loads == nil {
+		s.loads = make(map[string]int)
+	}
+	s.loads[url]++ // penalize the failing mirror so it sinks in future picks
+
+	var rest []string
+	for _, c := range candidates {
+		if c != url {
+			rest = append(rest, c)
+		}
+	}
+	if len(rest) == 0 {
+		return url
+	}
+	return s.leastLoaded(rest)
+}
+
+// RateLimiter bounds the rate at which range GETs consume bytes, applied
+// per host so a Pull sharing a machine with other workloads can cap its
+// egress without needing to reduce MaxStreams (which bounds concurrency,
+// not throughput).
+type RateLimiter interface {
+	// WaitN blocks until n bytes may be transferred for host, or ctx is
+	// done.
+	WaitN(ctx context.Context, host string, n int) error
+}
+
+// NewTokenBucketLimiter returns a RateLimiter that maintains an
+// independent token bucket per host, refilling at bytesPerSecond up to a
+// burst of one second's worth of tokens.
+func NewTokenBucketLimiter(bytesPerSecond int64) *TokenBucketLimiter {
+	return &TokenBucketLimiter{rate: bytesPerSecond, buckets: make(map[string]*tokenBucket)}
+}
+
+// TokenBucketLimiter is a per-host token-bucket RateLimiter.
+type TokenBucketLimiter struct {
+	rate int64 // bytes/sec
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	capacity float64
+	rate     float64 // tokens/sec
+}
+
+func (l *TokenBucketLimiter) bucket(host string) *tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &tokenBucket{
+			tokens:   float64(l.rate),
+			capacity: float64(l.rate),
+			rate:     float64(l.rate),
+		}
+		l.buckets[host] = b
+	}
+	return b
+}
+
+func (l *TokenBucketLimiter) WaitN(ctx context.Context, host string, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	b := l.bucket(host)
+	need := float64(n)
+	for need > 0 {
+		granted, d := b.reserve(need)
+		need -= granted
+		if need <= 0 {
+			return nil
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// reserve refills the bucket for elapsed time, then withdraws up to need
+// tokens, returning how many were granted. A need larger than the
+// bucket's capacity (one second's burst) can never be granted in a single
+// call, so reserve grants a partial withdrawal and returns the duration
+// to wait before the caller should ask again for the remainder, rather
+// than requiring the whole request to fit in the bucket at once.
+func (b *tokenBucket) reserve(need float64) (granted float64, wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if !b.last.IsZero() {
+		elapsed := now.Sub(b.last).Seconds()
+		b.tokens = min(b.capacity, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+
+	take := min(need, b.tokens)
+	b.tokens -= take
+	if take >= need {
+		return take, 0
+	}
+	remaining := min(need-take, b.capacity)
+	return take, time.Duration(remaining / b.rate * float64(time.Second))
+}
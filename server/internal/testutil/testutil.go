@@ -0,0 +1,12 @@
+// Package testutil provides small helpers shared by this module's tests.
+package testutil
+
+import "testing"
+
+// Check calls t.Fatal(err) if err is non-nil.
+func Check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}